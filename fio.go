@@ -19,29 +19,188 @@
 package main
 
 import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"math/rand"
 	"os"
 	"path/filepath"
-	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
+	"github.com/codahale/hdrhistogram"
 	humanize "github.com/dustin/go-humanize"
 	"github.com/minio/minio/pkg/disk"
 	"github.com/minio/minio/pkg/ellipses"
 	"github.com/minio/minio/pkg/env"
 	xioutil "github.com/minio/minio/pkg/ioutil"
-	"gonum.org/v1/gonum/stat"
 )
 
 const readBlockSize = 4 * humanize.MiByte // Default read block size 4MiB.
 
+// Latency histogram bounds, tracked in nanoseconds at 3 significant digits,
+// covering everything from a fast local I/O to a stalled 60s one.
+const (
+	histogramMin     = int64(time.Microsecond)
+	histogramMax     = int64(60 * time.Second)
+	histogramSigFigs = 3
+)
+
+// newLatencyHistogram returns a fresh, resettable latency histogram.
+func newLatencyHistogram() *hdrhistogram.Histogram {
+	return hdrhistogram.New(histogramMin, histogramMax, histogramSigFigs)
+}
+
+// printLatencyReport prints the percentile breakdown of a merged histogram
+// for the given I/O direction to w.
+func printLatencyReport(w io.Writer, label string, hist *hdrhistogram.Histogram) {
+	if hist.TotalCount() == 0 {
+		return
+	}
+	fmt.Fprintf(w, "%s latency percentiles:\n", label)
+	fmt.Fprintln(w, "  p50   ", time.Duration(hist.ValueAtQuantile(50)))
+	fmt.Fprintln(w, "  p75   ", time.Duration(hist.ValueAtQuantile(75)))
+	fmt.Fprintln(w, "  p90   ", time.Duration(hist.ValueAtQuantile(90)))
+	fmt.Fprintln(w, "  p95   ", time.Duration(hist.ValueAtQuantile(95)))
+	fmt.Fprintln(w, "  p99   ", time.Duration(hist.ValueAtQuantile(99)))
+	fmt.Fprintln(w, "  p99.9 ", time.Duration(hist.ValueAtQuantile(99.9)))
+	fmt.Fprintln(w, "  max   ", time.Duration(hist.Max()))
+}
+
+// printThroughput prints the MiB/s achieved moving nbytes over elapsed to w.
+func printThroughput(w io.Writer, label string, nbytes int64, elapsed time.Duration) {
+	if nbytes == 0 {
+		return
+	}
+	mibs := float64(nbytes) / humanize.MiByte / elapsed.Seconds()
+	fmt.Fprintf(w, "%s throughput: %.2f MiB/s\n", label, mibs)
+}
+
+// histReport is the percentile/throughput summary for one I/O direction,
+// either overall or for a single drive.
+type histReport struct {
+	P50Ms          float64 `json:"p50_ms"`
+	P75Ms          float64 `json:"p75_ms"`
+	P90Ms          float64 `json:"p90_ms"`
+	P95Ms          float64 `json:"p95_ms"`
+	P99Ms          float64 `json:"p99_ms"`
+	P999Ms         float64 `json:"p99_9_ms"`
+	MaxMs          float64 `json:"max_ms"`
+	ThroughputMiBs float64 `json:"throughput_mibs"`
+}
+
+func newHistReport(hist *hdrhistogram.Histogram, nbytes int64, elapsed time.Duration) histReport {
+	toMs := func(ns int64) float64 { return float64(ns) / float64(time.Millisecond) }
+	var mibs float64
+	if nbytes > 0 {
+		mibs = float64(nbytes) / humanize.MiByte / elapsed.Seconds()
+	}
+	return histReport{
+		P50Ms:          toMs(hist.ValueAtQuantile(50)),
+		P75Ms:          toMs(hist.ValueAtQuantile(75)),
+		P90Ms:          toMs(hist.ValueAtQuantile(90)),
+		P95Ms:          toMs(hist.ValueAtQuantile(95)),
+		P99Ms:          toMs(hist.ValueAtQuantile(99)),
+		P999Ms:         toMs(hist.ValueAtQuantile(99.9)),
+		MaxMs:          toMs(hist.Max()),
+		ThroughputMiBs: mibs,
+	}
+}
+
+// benchResult is the machine-readable record emitted for OUTPUT=json|csv at
+// the end of the run, so a nightly CI job can diff p99 latency across runs.
+type benchResult struct {
+	Timestamp time.Time              `json:"timestamp"`
+	Config    map[string]interface{} `json:"config"`
+	Write     *histReport            `json:"write,omitempty"`
+	Read      *histReport            `json:"read,omitempty"`
+	Drives    map[string]histReport  `json:"drives,omitempty"`
+	Errors    map[string]int64       `json:"errors"`
+}
+
+var csvHeader = []string{
+	"timestamp", "config",
+	"write_p50_ms", "write_p99_ms", "write_max_ms", "write_throughput_mibs",
+	"read_p50_ms", "read_p99_ms", "read_max_ms", "read_throughput_mibs",
+	"errors_no_space", "errors_permission", "errors_io_error", "errors_other",
+}
+
+func (r benchResult) csvRow() []string {
+	cfg, _ := json.Marshal(r.Config)
+	f := strconv.FormatFloat
+	var w, rd histReport
+	if r.Write != nil {
+		w = *r.Write
+	}
+	if r.Read != nil {
+		rd = *r.Read
+	}
+	return []string{
+		r.Timestamp.Format(time.RFC3339), string(cfg),
+		f(w.P50Ms, 'f', 3, 64), f(w.P99Ms, 'f', 3, 64), f(w.MaxMs, 'f', 3, 64), f(w.ThroughputMiBs, 'f', 3, 64),
+		f(rd.P50Ms, 'f', 3, 64), f(rd.P99Ms, 'f', 3, 64), f(rd.MaxMs, 'f', 3, 64), f(rd.ThroughputMiBs, 'f', 3, 64),
+		strconv.FormatInt(r.Errors["no_space"], 10), strconv.FormatInt(r.Errors["permission"], 10),
+		strconv.FormatInt(r.Errors["io_error"], 10), strconv.FormatInt(r.Errors["other"], 10),
+	}
+}
+
+// reportResult renders result in the requested format and writes it to
+// outputFile (appending) or stdout. format "text" reuses the familiar
+// printLatencyReport/printThroughput output plus a per-drive breakdown;
+// json and csv serialize result.
+func reportResult(writeHist, readHist *hdrhistogram.Histogram, writeBytes, readBytes int64, elapsed time.Duration, result benchResult, format, outputFile string) {
+	out := os.Stdout
+	if outputFile != "" {
+		f, err := os.OpenFile(outputFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		out = f
+	}
+
+	switch format {
+	case "json":
+		if err := json.NewEncoder(out).Encode(result); err != nil {
+			log.Fatal(err)
+		}
+	case "csv":
+		needsHeader := true
+		if fi, err := os.Stat(outputFile); err == nil && fi.Size() > 0 {
+			needsHeader = false
+		}
+		w := csv.NewWriter(out)
+		if needsHeader {
+			if err := w.Write(csvHeader); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if err := w.Write(result.csvRow()); err != nil {
+			log.Fatal(err)
+		}
+		w.Flush()
+	default:
+		fmt.Fprintln(out, "Config:", result.Config)
+		printLatencyReport(out, "Write", writeHist)
+		printLatencyReport(out, "Read", readHist)
+		printThroughput(out, "Write", writeBytes, elapsed)
+		printThroughput(out, "Read", readBytes, elapsed)
+		for drive, ds := range result.Drives {
+			fmt.Fprintf(out, "Drive %s: p99=%.3fms max=%.3fms throughput=%.2fMiB/s\n",
+				drive, ds.P99Ms, ds.MaxMs, ds.ThroughputMiBs)
+		}
+		fmt.Fprintln(out, "Errors:", result.Errors)
+	}
+}
+
 var pool = sync.Pool{
 	New: func() interface{} {
 		b := disk.AlignedBlock(readBlockSize)
@@ -96,28 +255,79 @@ func (r *nullReader) Read(b []byte) (int, error) {
 
 var debug = env.Get("DEBUG", "off") == "on"
 
-// CreateFile - creates the file.
-func write(obj int, drives []string, fileSize int64, tree bool) (time.Duration, error) {
+// Error counts by category, accumulated for the lifetime of the run and
+// surfaced in the final report. A single failed op is recorded here rather
+// than aborting the whole benchmark via log.Fatal.
+var (
+	errNoSpace    int64
+	errPermission int64
+	errIO         int64
+	errOther      int64
+)
+
+// classifyError buckets a filesystem error so a nightly run can tell "disk
+// full" apart from a transient EIO blip.
+func classifyError(err error) string {
+	switch {
+	case errors.Is(err, syscall.ENOSPC):
+		return "no_space"
+	case errors.Is(err, os.ErrPermission):
+		return "permission"
+	case errors.Is(err, syscall.EIO):
+		return "io_error"
+	default:
+		return "other"
+	}
+}
+
+// recordError classifies err and bumps the matching counter.
+func recordError(err error) {
+	switch classifyError(err) {
+	case "no_space":
+		atomic.AddInt64(&errNoSpace, 1)
+	case "permission":
+		atomic.AddInt64(&errPermission, 1)
+	case "io_error":
+		atomic.AddInt64(&errIO, 1)
+	default:
+		atomic.AddInt64(&errOther, 1)
+	}
+}
+
+// errorCounts snapshots the error counters into a report-friendly map.
+func errorCounts() map[string]int64 {
+	return map[string]int64{
+		"no_space":   atomic.LoadInt64(&errNoSpace),
+		"permission": atomic.LoadInt64(&errPermission),
+		"io_error":   atomic.LoadInt64(&errIO),
+		"other":      atomic.LoadInt64(&errOther),
+	}
+}
+
+// write - creates the file, returning the drive it landed on so callers
+// can build a per-drive breakdown.
+func write(obj int, drives []string, fileSize int64, tree bool) (time.Duration, string, error) {
 	var nBuf [32]byte
 	randASCIIBytes(nBuf[:])
 
 	rv := rand.New(rand.NewSource(time.Now().UnixNano())).Intn
+	drive := drives[rv(len(drives))]
 	var name string
 	if tree {
-		name = filepath.Join(drives[rv(len(drives))], fmt.Sprintf("%d/%s", obj, string(nBuf[:])))
+		name = filepath.Join(drive, fmt.Sprintf("%d/%s", obj, string(nBuf[:])))
 	} else {
-		name = filepath.Join(drives[rv(len(drives))], fmt.Sprintf("%d.%s", obj, string(nBuf[:])))
+		name = filepath.Join(drive, fmt.Sprintf("%d.%s", obj, string(nBuf[:])))
 	}
 
 	t := time.Now()
 
 	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
-		return 0, err
+		return 0, drive, err
 	}
 
 	w, err := disk.OpenFileDirectIO(name, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0666)
 	if err != nil {
-		return 0, err
+		return 0, drive, err
 	}
 
 	if fileSize > 0 {
@@ -125,7 +335,7 @@ func write(obj int, drives []string, fileSize int64, tree bool) (time.Duration,
 		err = Fallocate(int(w.Fd()), 0, fileSize)
 	}
 	if err != nil {
-		return 0, err
+		return 0, drive, err
 	}
 
 	defer func() {
@@ -138,11 +348,11 @@ func write(obj int, drives []string, fileSize int64, tree bool) (time.Duration,
 
 	written, err := xioutil.CopyAligned(w, io.LimitReader(&nullReader{}, fileSize), *bufp, fileSize)
 	if err != nil {
-		return 0, err
+		return 0, drive, err
 	}
 
 	if written != fileSize {
-		return 0, fmt.Errorf("unexpected file size written expected %d, got %d", fileSize, written)
+		return 0, drive, fmt.Errorf("unexpected file size written expected %d, got %d", fileSize, written)
 	}
 
 	d := time.Since(t)
@@ -150,24 +360,120 @@ func write(obj int, drives []string, fileSize int64, tree bool) (time.Duration,
 		fmt.Printf("object %s took more than a second to write\n", name)
 	}
 
-	return d, nil
+	return d, drive, nil
 }
 
-func concurrentWrite(obj int, drives []string, fileSize int64, nfiles int, totalIntervals []float64, tree bool) {
-	var wg sync.WaitGroup
-	wg.Add(int(nfiles))
-	for i := 0; i < int(nfiles); i++ {
-		i := i
-		go func(i int) {
-			defer wg.Done()
-			d, err := write(obj+i, drives, fileSize, tree)
+// readBlock reads a single, blockSize-sized, aligned chunk at off from the
+// file at name, using the O_DIRECT path like write does.
+func readBlock(name string, off, blockSize int64) (time.Duration, int64, error) {
+	r, err := disk.OpenFileDirectIO(name, os.O_RDONLY, 0)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer r.Close()
+
+	bufp := pool.Get().(*[]byte)
+	defer pool.Put(bufp)
+
+	t := time.Now()
+	read, err := xioutil.CopyAligned(ioutil.Discard, io.NewSectionReader(r, off, blockSize), *bufp, blockSize)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	d := time.Since(t)
+	if d > time.Second && debug {
+		fmt.Printf("object %s took more than a second to read\n", name)
+	}
+
+	return d, read, nil
+}
+
+// readSet is the pool of existing files a read/randread/randrw workload
+// reads from, discovered up front under DRIVES.
+type readSet struct {
+	names []string
+	sizes []int64
+
+	mu      sync.Mutex
+	nextIdx int
+	nextOff int64
+}
+
+// newReadSet walks drives and indexes every regular file found, along with
+// its size, so reads can pick aligned offsets without stat'ing on every op.
+func newReadSet(drives []string) (*readSet, error) {
+	rs := &readSet{}
+	for _, drive := range drives {
+		err := filepath.Walk(drive, func(path string, info os.FileInfo, err error) error {
 			if err != nil {
-				log.Fatal(err)
+				return err
+			}
+			if info.Mode().IsRegular() {
+				rs.names = append(rs.names, path)
+				rs.sizes = append(rs.sizes, info.Size())
 			}
-			totalIntervals[i] = float64(d)
-		}(i)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
 	}
-	wg.Wait()
+	if len(rs.names) == 0 {
+		return nil, fmt.Errorf("no existing files found under DRIVES, run with WORKLOAD=write first")
+	}
+	return rs, nil
+}
+
+// sequential returns the next file and offset in file order, wrapping around
+// once the whole set has been scanned. If no indexed file is at least
+// blockSize bytes, it falls back to offset 0 of the next file, the same
+// fallback random uses for size < blockSize, rather than spinning forever
+// looking for a file that qualifies.
+func (rs *readSet) sequential(blockSize int64) (string, int64) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	for attempts := 0; attempts <= len(rs.names); attempts++ {
+		if rs.nextIdx >= len(rs.names) {
+			rs.nextIdx, rs.nextOff = 0, 0
+		}
+		if rs.nextOff+blockSize <= rs.sizes[rs.nextIdx] {
+			name, off := rs.names[rs.nextIdx], rs.nextOff
+			rs.nextOff += blockSize
+			return name, off
+		}
+		rs.nextIdx++
+		rs.nextOff = 0
+	}
+	if rs.nextIdx >= len(rs.names) {
+		rs.nextIdx = 0
+	}
+	name := rs.names[rs.nextIdx]
+	rs.nextIdx++
+	rs.nextOff = 0
+	return name, 0
+}
+
+// random returns a uniformly random file and a uniformly random
+// blockSize-aligned offset within it.
+func (rs *readSet) random(rng *rand.Rand, blockSize int64) (string, int64) {
+	i := rng.Intn(len(rs.names))
+	name, size := rs.names[i], rs.sizes[i]
+	if size < blockSize {
+		return name, 0
+	}
+	return name, rng.Int63n(size/blockSize) * blockSize
+}
+
+// driveOf returns the configured drive name is rooted under, or name itself
+// if none match, so per-drive stats still have something to key on.
+func driveOf(name string, drives []string) string {
+	for _, d := range drives {
+		if strings.HasPrefix(name, d) {
+			return d
+		}
+	}
+	return name
 }
 
 // parseDrives will parse the drive parameter given.
@@ -190,6 +496,95 @@ func parseDrives(h string) []string {
 	return dst
 }
 
+// driveStats is the per-drive latency/throughput breakdown collected across
+// both reads and writes landing on that drive.
+type driveStats struct {
+	hist  *hdrhistogram.Histogram
+	bytes int64
+}
+
+// runWorkload drives nops I/O operations over a bounded pool of
+// concurrency*iodepth workers instead of one goroutine per file, so a large
+// nops doesn't translate into a goroutine explosion. isRead decides, per op
+// index, whether that op is a read or a write. A failed op is classified and
+// counted rather than aborting the run.
+func runWorkload(drives []string, fileSize int64, blockSize int64, nops int, concurrency, iodepth int, tree, sequentialRead bool, rs *readSet, isRead func(int) bool) (writeHist, readHist *hdrhistogram.Histogram, writeBytes, readBytes int64, perDrive map[string]*driveStats) {
+	writeHist, readHist = newLatencyHistogram(), newLatencyHistogram()
+	byDrive := make(map[string]*driveStats, len(drives))
+	var mu sync.Mutex
+
+	workers := concurrency * iodepth
+
+	// Feed op indices through a worker-sized buffer instead of one sized for
+	// all of nops, which defaults to NFILES=8M and would eagerly allocate a
+	// multi-megabyte channel just to enumerate indices.
+	opCh := make(chan int, workers)
+	go func() {
+		defer close(opCh)
+		for i := 0; i < nops; i++ {
+			opCh <- i
+		}
+	}()
+
+	mergeDrive := func(name string, d time.Duration, n int64) {
+		mu.Lock()
+		defer mu.Unlock()
+		drive := driveOf(name, drives)
+		ds, ok := byDrive[drive]
+		if !ok {
+			ds = &driveStats{hist: newLatencyHistogram()}
+			byDrive[drive] = ds
+		}
+		ds.hist.RecordValue(int64(d))
+		ds.bytes += n
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+			workerWriteHist, workerReadHist := newLatencyHistogram(), newLatencyHistogram()
+			for i := range opCh {
+				if isRead(i) {
+					var name string
+					var off int64
+					if sequentialRead {
+						name, off = rs.sequential(blockSize)
+					} else {
+						name, off = rs.random(rng, blockSize)
+					}
+					d, n, err := readBlock(name, off, blockSize)
+					if err != nil {
+						recordError(err)
+						continue
+					}
+					workerReadHist.RecordValue(int64(d))
+					atomic.AddInt64(&readBytes, n)
+					mergeDrive(name, d, n)
+				} else {
+					d, drive, err := write(i, drives, fileSize, tree)
+					if err != nil {
+						recordError(err)
+						continue
+					}
+					workerWriteHist.RecordValue(int64(d))
+					atomic.AddInt64(&writeBytes, fileSize)
+					mergeDrive(drive, d, fileSize)
+				}
+			}
+			mu.Lock()
+			writeHist.Merge(workerWriteHist)
+			readHist.Merge(workerReadHist)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	return writeHist, readHist, writeBytes, readBytes, byDrive
+}
+
 func main() {
 	drives := parseDrives(env.Get("DRIVES", ""))
 	if len(drives) == 0 {
@@ -203,6 +598,10 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
+	blockSize, err := humanize.ParseBytes(env.Get("BLOCKSIZE", "128KiB"))
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	nfiles, err := humanize.ParseBytes(env.Get("NFILES", "8M"))
 	if err != nil {
@@ -214,21 +613,82 @@ func main() {
 		log.Fatal(err)
 	}
 
-	var totalIntervals = make([]float64, nfiles)
+	iodepth, err := strconv.Atoi(env.Get("IODEPTH", "1"))
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	if int(nfiles) < concurrency {
-		concurrentWrite(0, drives, int64(fileSize), int(nfiles), totalIntervals[:int(nfiles)], tree)
-	} else {
-		var i int
-		for i < int(nfiles) {
-			concurrentWrite(i, drives, int64(fileSize), concurrency, totalIntervals[i:i+concurrency], tree)
-			i = i + concurrency
-		}
-	}
-	sort.Float64s(totalIntervals)
-	meanInterval, stdInterval := stat.MeanStdDev(totalIntervals, nil)
-	fmt.Println("Mean time taken", time.Duration(meanInterval))
-	fmt.Println("Standard deviation time taken", time.Duration(stdInterval))
-	fmt.Println("Fastest time taken", time.Duration(totalIntervals[0]))
-	fmt.Println("Slowest time taken", time.Duration(totalIntervals[len(totalIntervals)-1]))
+	rwMixRead, err := strconv.Atoi(env.Get("RWMIXREAD", "50"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	workload := env.Get("WORKLOAD", "write")
+	output := env.Get("OUTPUT", "text")
+	outputFile := env.Get("OUTPUT_FILE", "")
+
+	var rs *readSet
+	var isRead func(int) bool
+	sequentialRead := workload == "read"
+
+	switch workload {
+	case "write":
+		isRead = func(int) bool { return false }
+	case "read", "randread":
+		rs, err = newReadSet(drives)
+		if err != nil {
+			log.Fatal(err)
+		}
+		isRead = func(int) bool { return true }
+	case "randrw":
+		rs, err = newReadSet(drives)
+		if err != nil {
+			log.Fatal(err)
+		}
+		// Deterministic on the op index rather than a shared *rand.Rand, since
+		// isRead is called concurrently from every worker and math/rand.Rand
+		// is not safe for concurrent use.
+		isRead = func(i int) bool { return i%100 < rwMixRead }
+	default:
+		log.Fatalf("unknown WORKLOAD %q, expected write, read, randread or randrw", workload)
+	}
+
+	start := time.Now()
+	writeHist, readHist, writeBytes, readBytes, perDrive := runWorkload(
+		drives, int64(fileSize), int64(blockSize), int(nfiles), concurrency, iodepth, tree, sequentialRead, rs, isRead)
+	elapsed := time.Since(start)
+
+	cfg := map[string]interface{}{
+		"drives":      drives,
+		"concurrency": concurrency,
+		"filesize":    fileSize,
+		"blocksize":   blockSize,
+		"nfiles":      nfiles,
+		"tree":        tree,
+		"iodepth":     iodepth,
+		"rwmixread":   rwMixRead,
+		"workload":    workload,
+	}
+
+	result := benchResult{
+		Timestamp: time.Now(),
+		Config:    cfg,
+		Errors:    errorCounts(),
+	}
+	if writeHist.TotalCount() > 0 {
+		r := newHistReport(writeHist, writeBytes, elapsed)
+		result.Write = &r
+	}
+	if readHist.TotalCount() > 0 {
+		r := newHistReport(readHist, readBytes, elapsed)
+		result.Read = &r
+	}
+	if len(perDrive) > 0 {
+		result.Drives = make(map[string]histReport, len(perDrive))
+		for drive, ds := range perDrive {
+			result.Drives[drive] = newHistReport(ds.hist, ds.bytes, elapsed)
+		}
+	}
+
+	reportResult(writeHist, readHist, writeBytes, readBytes, elapsed, result, output, outputFile)
 }
@@ -19,32 +19,54 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"crypto/tls"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
+	"math/rand"
 	"net"
 	"net/http"
-	"sort"
+	"os"
+	"os/signal"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/codahale/hdrhistogram"
+	humanize "github.com/dustin/go-humanize"
 	"github.com/gorilla/mux"
 	xhttp "github.com/minio/minio/cmd/http"
 	"github.com/minio/minio/cmd/logger"
 	"github.com/minio/minio/cmd/rest"
+	"github.com/minio/minio/pkg/ellipses"
 	"github.com/minio/minio/pkg/env"
-	"gonum.org/v1/gonum/stat"
+	"golang.org/x/time/rate"
 )
 
 var (
-	client    bool
-	defaultTR bool
-	url       string
+	client     bool
+	defaultTR  bool
+	url        string
+	urlList    string
+	method     string
+	bodyPath   string
+	bodySize   string
+	duration   time.Duration
+	requests   int64
+	qps        float64
+	warmup     time.Duration
+	headers    headerFlags
+	output     string
+	outputFile string
 )
 
 var globalDNSCache = xhttp.NewDNSCache(10*time.Second, 10*time.Second, logger.LogOnceIf)
@@ -53,6 +75,299 @@ func init() {
 	flag.BoolVar(&client, "client", false, "indicates if its a client")
 	flag.BoolVar(&defaultTR, "defaultTR", false, "indicates if Go default transport to use")
 	flag.StringVar(&url, "url", "http://localhost:9090", "url to the server")
+	flag.StringVar(&urlList, "urls", "", "comma-separated list of URLs to round-robin requests across, supports {1...4} ellipses expansion (overrides -url)")
+	flag.StringVar(&method, "method", http.MethodGet, "HTTP method to use for every request")
+	flag.Var(&headers, "H", "an HTTP header to send, as \"Key: Value\"; repeatable")
+	flag.StringVar(&bodyPath, "body", "", "path to a file to use as the request body")
+	flag.StringVar(&bodySize, "body-size", "0", "size of a random request body to generate if -body is not set, e.g. 64KiB")
+	flag.DurationVar(&duration, "duration", 0, "run the benchmark for this long, 0 means unbounded (governed by -requests or Ctrl-C instead)")
+	flag.Int64Var(&requests, "requests", 0, "stop after this many total requests, 0 means unbounded (governed by -duration or Ctrl-C instead)")
+	flag.Float64Var(&qps, "qps", 0, "global rate limit across all workers in requests/sec, 0 means unlimited")
+	flag.DurationVar(&warmup, "warmup", 0, "warmup period before latencies start counting towards the report")
+	flag.StringVar(&output, "output", "text", "result format: text, json or csv")
+	flag.StringVar(&outputFile, "output-file", "", "append the result record to this file instead of printing to stdout")
+}
+
+// headerFlags collects repeated -H "Key: Value" flags.
+type headerFlags []string
+
+func (h *headerFlags) String() string { return strings.Join(*h, ",") }
+
+func (h *headerFlags) Set(v string) error {
+	*h = append(*h, v)
+	return nil
+}
+
+// httpHeader parses the collected -H flags into an http.Header.
+func (h headerFlags) httpHeader() http.Header {
+	hdr := make(http.Header, len(h))
+	for _, kv := range h {
+		k, v, ok := strings.Cut(kv, ":")
+		if !ok {
+			log.Fatalf("invalid header %q, expected \"Key: Value\"", kv)
+		}
+		hdr.Add(strings.TrimSpace(k), strings.TrimSpace(v))
+	}
+	return hdr
+}
+
+const asciiLetters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ1234567890()"
+
+var asciiLetterBytes [len(asciiLetters)]byte
+
+func init() {
+	for i, v := range asciiLetters {
+		asciiLetterBytes[i] = byte(v)
+	}
+}
+
+// randASCIIBytes fill destination with pseudorandom ASCII characters [a-zA-Z0-9()].
+// Should never be considered for true random data generation.
+func randASCIIBytes(dst []byte) {
+	// Use a single seed.
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	v := rng.Uint64()
+	rnd := uint32(v)
+	rnd2 := uint32(v >> 32)
+	for i := range dst {
+		dst[i] = asciiLetterBytes[int(rnd>>16)%len(asciiLetterBytes)]
+		rnd ^= rnd2
+		rnd *= 2654435761
+	}
+}
+
+// parseURLs expands a comma-separated, ellipses-capable URL list the same
+// way parseDrives expands DRIVES on the fio side. An empty s falls back to
+// the single -url flag.
+func parseURLs(s string) []string {
+	if s == "" {
+		return []string{url}
+	}
+	raw := strings.Split(s, ",")
+	dst := make([]string, 0, len(raw))
+	for _, u := range raw {
+		if !ellipses.HasEllipses(u) {
+			dst = append(dst, u)
+			continue
+		}
+		patterns, err := ellipses.FindEllipsesPatterns(u)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, p := range patterns {
+			dst = append(dst, p.Expand()...)
+		}
+	}
+	return dst
+}
+
+var urlIdx int64
+
+// nextURL round-robins across urls using a shared atomic counter.
+func nextURL(urls []string) string {
+	i := atomic.AddInt64(&urlIdx, 1)
+	return urls[int(i)%len(urls)]
+}
+
+// Latency histogram bounds, tracked in nanoseconds at 3 significant digits,
+// covering everything from sub-millisecond internode hops to a stalled 60s
+// request.
+const (
+	histogramMin     = int64(time.Microsecond)
+	histogramMax     = int64(60 * time.Second)
+	histogramSigFigs = 3
+)
+
+// newLatencyHistogram returns a fresh, resettable latency histogram.
+func newLatencyHistogram() *hdrhistogram.Histogram {
+	return hdrhistogram.New(histogramMin, histogramMax, histogramSigFigs)
+}
+
+// printLatencyReportTo prints the percentile breakdown of a merged histogram
+// to w.
+func printLatencyReportTo(w io.Writer, hist *hdrhistogram.Histogram) {
+	fmt.Fprintln(w, "Latency percentiles:")
+	fmt.Fprintln(w, "  p50   ", time.Duration(hist.ValueAtQuantile(50)))
+	fmt.Fprintln(w, "  p75   ", time.Duration(hist.ValueAtQuantile(75)))
+	fmt.Fprintln(w, "  p90   ", time.Duration(hist.ValueAtQuantile(90)))
+	fmt.Fprintln(w, "  p95   ", time.Duration(hist.ValueAtQuantile(95)))
+	fmt.Fprintln(w, "  p99   ", time.Duration(hist.ValueAtQuantile(99)))
+	fmt.Fprintln(w, "  p99.9 ", time.Duration(hist.ValueAtQuantile(99.9)))
+	fmt.Fprintln(w, "  max   ", time.Duration(hist.Max()))
+}
+
+// benchResult is the machine-readable record emitted for -output json|csv at
+// the end of the run, so a nightly CI job can diff p99 latency across runs.
+type benchResult struct {
+	Timestamp time.Time              `json:"timestamp"`
+	Config    map[string]interface{} `json:"config"`
+	P50Ms     float64                `json:"p50_ms"`
+	P75Ms     float64                `json:"p75_ms"`
+	P90Ms     float64                `json:"p90_ms"`
+	P95Ms     float64                `json:"p95_ms"`
+	P99Ms     float64                `json:"p99_ms"`
+	P999Ms    float64                `json:"p99_9_ms"`
+	MaxMs     float64                `json:"max_ms"`
+	Errors    map[string]int64       `json:"errors"`
+}
+
+func newBenchResult(cfg map[string]interface{}, hist *hdrhistogram.Histogram, errs map[string]int64) benchResult {
+	toMs := func(ns int64) float64 { return float64(ns) / float64(time.Millisecond) }
+	return benchResult{
+		Timestamp: time.Now(),
+		Config:    cfg,
+		P50Ms:     toMs(hist.ValueAtQuantile(50)),
+		P75Ms:     toMs(hist.ValueAtQuantile(75)),
+		P90Ms:     toMs(hist.ValueAtQuantile(90)),
+		P95Ms:     toMs(hist.ValueAtQuantile(95)),
+		P99Ms:     toMs(hist.ValueAtQuantile(99)),
+		P999Ms:    toMs(hist.ValueAtQuantile(99.9)),
+		MaxMs:     toMs(hist.Max()),
+		Errors:    errs,
+	}
+}
+
+var csvHeader = []string{
+	"timestamp", "config", "p50_ms", "p75_ms", "p90_ms", "p95_ms", "p99_ms", "p99_9_ms", "max_ms",
+	"errors_dial", "errors_tls", "errors_response_header_timeout", "errors_non_2xx_status", "errors_other",
+}
+
+func (r benchResult) csvRow() []string {
+	cfg, _ := json.Marshal(r.Config)
+	f := strconv.FormatFloat
+	return []string{
+		r.Timestamp.Format(time.RFC3339), string(cfg),
+		f(r.P50Ms, 'f', 3, 64), f(r.P75Ms, 'f', 3, 64), f(r.P90Ms, 'f', 3, 64),
+		f(r.P95Ms, 'f', 3, 64), f(r.P99Ms, 'f', 3, 64), f(r.P999Ms, 'f', 3, 64), f(r.MaxMs, 'f', 3, 64),
+		strconv.FormatInt(r.Errors["dial"], 10), strconv.FormatInt(r.Errors["tls"], 10),
+		strconv.FormatInt(r.Errors["response_header_timeout"], 10), strconv.FormatInt(r.Errors["non_2xx_status"], 10),
+		strconv.FormatInt(r.Errors["other"], 10),
+	}
+}
+
+// reportResult renders hist/result in the requested format and writes it to
+// outputFile (appending) or stdout. format "text" uses hist directly so the
+// familiar percentile formatting is preserved; json and csv serialize result.
+func reportResult(hist *hdrhistogram.Histogram, result benchResult, format, outputFile string) {
+	out := os.Stdout
+	if outputFile != "" {
+		f, err := os.OpenFile(outputFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		out = f
+	}
+
+	switch format {
+	case "json":
+		if err := json.NewEncoder(out).Encode(result); err != nil {
+			log.Fatal(err)
+		}
+	case "csv":
+		needsHeader := true
+		if fi, err := os.Stat(outputFile); err == nil && fi.Size() > 0 {
+			needsHeader = false
+		}
+		w := csv.NewWriter(out)
+		if needsHeader {
+			if err := w.Write(csvHeader); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if err := w.Write(result.csvRow()); err != nil {
+			log.Fatal(err)
+		}
+		w.Flush()
+	default:
+		fmt.Fprintln(out, "Config:", result.Config)
+		printLatencyReportTo(out, hist)
+		fmt.Fprintln(out, "Errors:", result.Errors)
+	}
+}
+
+// Rolling 1-second counters updated by the request goroutines and drained by
+// reportRolling. There is only ever one client loop running per process, so
+// these are kept as package globals rather than threaded through.
+var (
+	rollingRequests int64
+	rollingErrors   int64
+	inFlight        int64
+)
+
+// Error counts by category, accumulated for the lifetime of the run and
+// surfaced in the final report. A single request failure is recorded here
+// rather than aborting the whole benchmark via log.Fatal.
+var (
+	errDial    int64
+	errTLS     int64
+	errTimeout int64
+	errStatus  int64
+	errOther   int64
+)
+
+// classifyError buckets a request error the way plow/hey-style benchmarking
+// tools do, so a nightly run can tell a DNS blip from a TLS misconfiguration.
+func classifyError(err error) string {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return "dial"
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return "timeout"
+	}
+	if _, ok := err.(tls.RecordHeaderError); ok {
+		return "tls"
+	}
+	if strings.Contains(err.Error(), "tls:") || strings.Contains(err.Error(), "x509:") {
+		return "tls"
+	}
+	return "other"
+}
+
+// recordError classifies err and bumps the matching counter.
+func recordError(err error) {
+	atomic.AddInt64(&rollingErrors, 1)
+	switch classifyError(err) {
+	case "dial":
+		atomic.AddInt64(&errDial, 1)
+	case "timeout":
+		atomic.AddInt64(&errTimeout, 1)
+	case "tls":
+		atomic.AddInt64(&errTLS, 1)
+	default:
+		atomic.AddInt64(&errOther, 1)
+	}
+}
+
+// errorCounts snapshots the error counters into a report-friendly map.
+func errorCounts() map[string]int64 {
+	return map[string]int64{
+		"dial":                    atomic.LoadInt64(&errDial),
+		"tls":                     atomic.LoadInt64(&errTLS),
+		"response_header_timeout": atomic.LoadInt64(&errTimeout),
+		"non_2xx_status":          atomic.LoadInt64(&errStatus),
+		"other":                   atomic.LoadInt64(&errOther),
+	}
+}
+
+// reportRolling prints the current RPS, in-flight requests, and error count
+// once a second until stop is closed. Always writes to stderr so it never
+// interleaves with a json/csv record written to stdout.
+func reportRolling(stop <-chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			rps := atomic.SwapInt64(&rollingRequests, 0)
+			errs := atomic.SwapInt64(&rollingErrors, 0)
+			fmt.Fprintf(os.Stderr, "rps=%d inflight=%d errors=%d\n", rps, atomic.LoadInt64(&inFlight), errs)
+		}
+	}
 }
 
 func newInternodeDefaultTransport(tlsConfig *tls.Config, dialTimeout time.Duration) http.RoundTripper {
@@ -104,6 +419,52 @@ func newInternodeHTTPTransport(tlsConfig *tls.Config, dialTimeout time.Duration)
 	return tr
 }
 
+// doRequest issues a single request against reqURL, recording its latency
+// into workerHist which is private to the calling goroutine to avoid
+// contention. Latencies measured during warmup are still timed (so the
+// limiter and rolling stats see real traffic) but are not recorded into
+// workerHist.
+func doRequest(clnt *http.Client, workerHist *hdrhistogram.Histogram, warmingUp bool, reqURL string, hdr http.Header, body []byte) {
+	var bodyReader io.Reader
+	if body != nil {
+		bodyReader = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(context.Background(), method, reqURL, bodyReader)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for k, vv := range hdr {
+		for _, v := range vv {
+			req.Header.Add(k, v)
+		}
+	}
+
+	atomic.AddInt64(&inFlight, 1)
+	t := time.Now()
+	resp, err := clnt.Do(req)
+	atomic.AddInt64(&inFlight, -1)
+	if err != nil {
+		recordError(err)
+		return
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		atomic.AddInt64(&rollingErrors, 1)
+		atomic.AddInt64(&errStatus, 1)
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		return
+	}
+
+	if !warmingUp {
+		workerHist.RecordValue(int64(time.Since(t)))
+	}
+	atomic.AddInt64(&rollingRequests, 1)
+
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+}
+
 func main() {
 	flag.Parse()
 
@@ -117,41 +478,110 @@ func main() {
 		log.Fatal(err)
 	}
 	if client {
-		for {
-			var totalIntervals = make([]float64, concurrency)
-			var wg sync.WaitGroup
-			wg.Add(concurrency)
-			for i := 0; i < concurrency; i++ {
-				i := i
-				go func() {
-					defer wg.Done()
-					req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, url, nil)
-					if err != nil {
-						log.Fatal(err)
+		urls := parseURLs(urlList)
+
+		hdr := headers.httpHeader()
+
+		var body []byte
+		if bodyPath != "" {
+			b, err := ioutil.ReadFile(bodyPath)
+			if err != nil {
+				log.Fatal(err)
+			}
+			body = b
+		} else if sz, err := humanize.ParseBytes(bodySize); err != nil {
+			log.Fatal(err)
+		} else if sz > 0 {
+			body = make([]byte, sz)
+			randASCIIBytes(body)
+		}
+
+		stop := make(chan struct{})
+		var stopOnce sync.Once
+		closeStop := func() { stopOnce.Do(func() { close(stop) }) }
+
+		sigCh := make(chan os.Signal, 1)
+		signal.Notify(sigCh, os.Interrupt)
+		go func() {
+			<-sigCh
+			fmt.Fprintln(os.Stderr, "received interrupt, draining in-flight requests...")
+			closeStop()
+		}()
+
+		if duration > 0 {
+			time.AfterFunc(warmup+duration, closeStop)
+		}
+
+		var limiter *rate.Limiter
+		if qps > 0 {
+			limiter = rate.NewLimiter(rate.Limit(qps), concurrency)
+		}
+
+		// Cancelled when stop closes, so a worker blocked in limiter.Wait
+		// under a low -qps doesn't keep waiting for a token past the point
+		// where the run should have drained.
+		limiterCtx, cancelLimiterCtx := context.WithCancel(context.Background())
+		go func() {
+			<-stop
+			cancelLimiterCtx()
+		}()
+
+		go reportRolling(stop)
+
+		start := time.Now()
+		var issued int64
+		hist := newLatencyHistogram()
+		var histMu sync.Mutex
+
+		var wg sync.WaitGroup
+		wg.Add(concurrency)
+		for i := 0; i < concurrency; i++ {
+			go func() {
+				defer wg.Done()
+				workerHist := newLatencyHistogram()
+				for {
+					select {
+					case <-stop:
+						histMu.Lock()
+						hist.Merge(workerHist)
+						histMu.Unlock()
+						return
+					default:
 					}
-					t := time.Now()
-					resp, err := clnt.Do(req)
-					if err != nil {
-						log.Fatal(err)
+					warmingUp := time.Since(start) < warmup
+					if !warmingUp && requests > 0 && atomic.AddInt64(&issued, 1) > requests {
+						histMu.Lock()
+						hist.Merge(workerHist)
+						histMu.Unlock()
+						return
 					}
-					if resp.StatusCode != http.StatusOK {
-						log.Fatal("server returned unexpected response code")
+					if limiter != nil {
+						if err := limiter.Wait(limiterCtx); err != nil {
+							histMu.Lock()
+							hist.Merge(workerHist)
+							histMu.Unlock()
+							return
+						}
 					}
-					totalIntervals[i] = float64(time.Since(t))
-					io.Copy(ioutil.Discard, resp.Body)
-					resp.Body.Close()
-				}()
-			}
-			wg.Wait()
-			sort.Float64s(totalIntervals)
-			meanInterval, stdInterval := stat.MeanStdDev(totalIntervals, nil)
-			fmt.Println("Mean time taken", time.Duration(meanInterval))
-			fmt.Println("Standard deviation time taken", time.Duration(stdInterval))
-			fmt.Println("Fastest time taken", time.Duration(totalIntervals[0]))
-			fmt.Println("Slowest time taken", time.Duration(totalIntervals[len(totalIntervals)-1]))
-			time.Sleep(3 * time.Second)
-			fmt.Println("Continuing the next set of runs")
+					doRequest(&clnt, workerHist, warmingUp, nextURL(urls), hdr, body)
+				}
+			}()
 		}
+		wg.Wait()
+		closeStop()
+
+		cfg := map[string]interface{}{
+			"concurrency": concurrency,
+			"urls":        urls,
+			"method":      method,
+			"qps":         qps,
+			"warmup":      warmup.String(),
+			"duration":    duration.String(),
+			"requests":    requests,
+		}
+		result := newBenchResult(cfg, hist, errorCounts())
+		reportResult(hist, result, output, outputFile)
+		return
 	}
 
 	router := mux.NewRouter().SkipClean(true).UseEncodedPath()